@@ -0,0 +1,136 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"sort"
+)
+
+// CanonMode selects a Canonical XML serialization for an Encoder.
+type CanonMode int
+
+const (
+	// CanonNone is the default, repo-native serialization: attributes
+	// in struct field order, the default Escape for attribute values
+	// and, unless EscapeText(true) is set, for text.
+	CanonNone CanonMode = iota
+	// CanonC14N10 produces Canonical XML 1.0 output, as defined by
+	// https://www.w3.org/TR/xml-c14n.
+	//
+	// Exclusive XML Canonicalization (https://www.w3.org/TR/xml-exc-c14n)
+	// only differs from this when canonicalizing a subtree detached
+	// from its parsed ancestor context, carrying forward namespace
+	// declarations the subtree doesn't itself use. Encode only ever
+	// writes namespace declarations an element or its descendants
+	// actually use, so there is nothing here for a separate
+	// CanonExclusive mode to do differently; it was removed rather
+	// than kept as a no-op alias.
+	CanonC14N10
+)
+
+// Canonicalize switches the encoder into the given canonicalization
+// mode: attributes are sorted by namespace URI and then local name,
+// namespace declarations are emitted only where needed, and text and
+// attribute values use the escaping Canonical XML requires. This is a
+// prerequisite for signing marshaled output (XML-DSig over SAML,
+// XMPP dialback, WS-Security), none of which tolerates the
+// struct-field-order attribute emission Encode otherwise produces.
+//
+// Canonicalize must be called before Encode; it has no effect on
+// output already written.
+func (enc *Encoder) Canonicalize(mode CanonMode) {
+	enc.printer.canon = mode
+}
+
+// CanonicalMarshal returns the Canonical XML 1.0 encoding of v. It is
+// the canonicalizing equivalent of Marshal.
+func CanonicalMarshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.Canonicalize(CanonC14N10)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// sortAttrOrder reorders the attribute indices in order by namespace
+// URI and then local name, applying any MarshalerAttr/Attr-field
+// override so the sort reflects what will actually be written.
+func sortAttrOrder(order []int, tinfo *typeInfo, overrides map[int]Attr) {
+	key := func(i int) (string, string) {
+		if a, ok := overrides[i]; ok {
+			return a.Name.Space, a.Name.Local
+		}
+		f := &tinfo.fields[i]
+		return f.xmlns, f.name
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, la := key(order[a])
+		sb, lb := key(order[b])
+		if sa != sb {
+			return sa < sb
+		}
+		return la < lb
+	})
+}
+
+// canonEscapeText writes s as XML character data per the Canonical
+// XML rules: '&', '<' and '>' are always escaped, and bare '\r' is
+// normalized to a character reference.
+func canonEscapeText(w *printer, s []byte) {
+	last := 0
+	for i, c := range s {
+		var esc string
+		switch c {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			continue
+		}
+		w.Write(s[last:i])
+		w.WriteString(esc)
+		last = i + 1
+	}
+	w.Write(s[last:])
+}
+
+// canonEscapeAttr writes s as an XML attribute value per the
+// Canonical XML rules: '&', '<', '"', tab, newline and carriage
+// return are all escaped as character references so the value
+// round-trips unchanged through any XML parser.
+func canonEscapeAttr(w *printer, s []byte) {
+	last := 0
+	for i, c := range s {
+		var esc string
+		switch c {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '"':
+			esc = "&quot;"
+		case '\t':
+			esc = "&#x9;"
+		case '\n':
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			continue
+		}
+		w.Write(s[last:i])
+		w.WriteString(esc)
+		last = i + 1
+	}
+	w.Write(s[last:])
+}
@@ -0,0 +1,29 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import "testing"
+
+type canonElt struct {
+	XMLName Name   `xml:"e"`
+	B       string `xml:"b,attr"`
+	A       string `xml:"a,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// TestCanonicalMarshal proves CanonicalMarshal sorts attributes by
+// name regardless of struct field order, and escapes a bare '\r' in
+// character data as Canonical XML requires.
+func TestCanonicalMarshal(t *testing.T) {
+	v := canonElt{B: "2", A: "1", Text: "x\ry"}
+	got, err := CanonicalMarshal(v)
+	if err != nil {
+		t.Fatalf("CanonicalMarshal(%v): %s", v, err)
+	}
+	want := `<e a="1" b="2">x&#xD;y</e>`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// EscapeText sets whether the encoder writes character data with
+// minimal escaping: only '&', '<' and '>' become entity references,
+// and '\r'/'\n' are passed through unchanged. The default escaping
+// additionally turns '\r' and '\n' into character references, which
+// is correct but unreadable for text that is mostly meant to be
+// read, such as XMPP <body> elements.
+//
+// EscapeText has no effect on attribute values, nor in a
+// canonicalization mode set via Canonicalize, which has its own
+// fixed escaping rules.
+func (enc *Encoder) EscapeText(minimal bool) {
+	enc.printer.minimalText = minimal
+}
+
+var ddBracket = []byte("]]>")
+
+// minimalEscapeText writes s as XML character data, escaping only
+// '&', '<' and '>' (the latter so a bare "]]>" can never appear in
+// text). Unlike the default Escape, it leaves '\r' and '\n' alone.
+func minimalEscapeText(w *printer, s []byte) {
+	last := 0
+	for i, c := range s {
+		var esc string
+		switch c {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		default:
+			continue
+		}
+		w.Write(s[last:i])
+		w.WriteString(esc)
+		last = i + 1
+	}
+	w.Write(s[last:])
+}
+
+// writeCDATA writes data as one or more CDATA sections, splitting on
+// any "]]>" it contains since that sequence cannot appear inside a
+// CDATA section.
+func writeCDATA(p *printer, data []byte) {
+	p.WriteString("<![CDATA[")
+	for {
+		i := bytes.Index(data, ddBracket)
+		if i < 0 {
+			break
+		}
+		// End this section right after "]]", close it, and start a
+		// fresh one for the rest so the closing "]]>" marker itself
+		// never appears inside a section.
+		p.Write(data[:i+2])
+		p.WriteString("]]><![CDATA[")
+		data = data[i+2:]
+	}
+	p.Write(data)
+	p.WriteString("]]>")
+}
+
+// charDataBytes renders a chardata field's value to text, matching
+// the set of kinds marshalStruct already knows how to write as
+// element content. It returns nil for any other kind, which leaves
+// the field's text unwritten, just as before this field gained the
+// option of going through CDATA.
+func charDataBytes(vf reflect.Value) []byte {
+	var scratch [64]byte
+	switch vf.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(scratch[:0], vf.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(scratch[:0], vf.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.AppendFloat(scratch[:0], vf.Float(), 'g', -1, vf.Type().Bits())
+	case reflect.Bool:
+		return strconv.AppendBool(scratch[:0], vf.Bool())
+	case reflect.String:
+		return []byte(vf.String())
+	case reflect.Slice:
+		if elem, ok := vf.Interface().([]byte); ok {
+			return elem
+		}
+	case reflect.Struct:
+		if vf.Type() == timeType {
+			return []byte(vf.Interface().(time.Time).Format(time.RFC3339Nano))
+		}
+	}
+	return nil
+}
+
+// isCDataField reports whether finfo's struct tag carries the
+// ",cdata" option, in which case its text is wrapped in a CDATA
+// section instead of being escaped. This lets a single document mix
+// escaped and CDATA fields, which SOAP payloads embedding
+// pre-serialized XML commonly need.
+//
+// Canonical XML has no CDATA construct, so marshalStruct only
+// consults this when the encoder is not in a canonicalization mode;
+// a ",cdata" field is written as plain escaped text there instead.
+func isCDataField(typ reflect.Type, finfo *fieldInfo) bool {
+	sf := typ.FieldByIndex(finfo.idx)
+	return hasTagOption(sf.Tag.Get("xml"), "cdata")
+}
@@ -0,0 +1,51 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"testing"
+)
+
+type cdataElt struct {
+	XMLName Name   `xml:"e"`
+	Text    string `xml:",chardata,cdata"`
+}
+
+// TestMarshalCDATA proves a ",cdata" field is wrapped in a CDATA
+// section, split around an embedded "]]>" so the closing marker never
+// appears inside a section.
+func TestMarshalCDATA(t *testing.T) {
+	v := cdataElt{Text: "a ]]> b"}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal(%v): %s", v, err)
+	}
+	want := `<e><![CDATA[a ]]]]><![CDATA[> b]]></e>`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type textElt struct {
+	XMLName Name   `xml:"e"`
+	Text    string `xml:",chardata"`
+}
+
+// TestEscapeTextMinimal proves EscapeText(true) escapes only '&', '<'
+// and '>', leaving '\n' untouched, unlike the default Escape.
+func TestEscapeTextMinimal(t *testing.T) {
+	v := textElt{Text: "a<b>&c\nd"}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.EscapeText(true)
+	if err := enc.Encode(v); err != nil {
+		t.Fatalf("Encode(%v): %s", v, err)
+	}
+	want := "<e>a&lt;b&gt;&amp;c\nd</e>"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
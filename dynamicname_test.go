@@ -0,0 +1,90 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import "testing"
+
+type davProp struct {
+	Name  Name   `xml:",name"`
+	Value string `xml:",chardata"`
+}
+
+// TestDynamicNameField proves a ",name"-tagged xml.Name field supplies
+// the element's own name and is not also written out again as a
+// bogus <Name><Space>...</Space><Local>...</Local></Name> child.
+func TestDynamicNameField(t *testing.T) {
+	v := davProp{Name: Name{Space: "DAV:", Local: "displayname"}, Value: "hello"}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal(%v): %s", v, err)
+	}
+	want := `<displayname xmlns="DAV:">hello</displayname>`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type davPropPtr struct {
+	Name  *Name  `xml:",name"`
+	Value string `xml:",chardata"`
+}
+
+// TestDynamicNameFieldPointer proves a ",name"-tagged *xml.Name field
+// is recognized the same way as a plain xml.Name field.
+func TestDynamicNameFieldPointer(t *testing.T) {
+	v := davPropPtr{Name: &Name{Space: "DAV:", Local: "displayname"}, Value: "hello"}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal(%v): %s", v, err)
+	}
+	want := `<displayname xmlns="DAV:">hello</displayname>`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type davAttrHolder struct {
+	XMLName Name   `xml:"prop"`
+	Attr    Attr   `xml:"static,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// TestDynamicAttrField proves an Attr-typed field is emitted with its
+// own runtime Name, not the static tag name used to recognize it as
+// an attribute field in the first place.
+func TestDynamicAttrField(t *testing.T) {
+	v := davAttrHolder{Attr: Attr{Name: Name{Local: "lang"}, Value: "en"}, Value: "hi"}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal(%v): %s", v, err)
+	}
+	want := `<prop lang="en">hi</prop>`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type davAttrHolderNS struct {
+	XMLName Name   `xml:"DAV: prop"`
+	Attr    Attr   `xml:"static,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// TestDynamicAttrFieldOwnNamespace extends TestDynamicAttrField: when
+// an Attr-typed field's runtime namespace matches the enclosing
+// element's own namespace, it must still get its own prefix rather
+// than silently losing the namespace by reusing the element's
+// unprefixed xmlns="DAV:" declaration.
+func TestDynamicAttrFieldOwnNamespace(t *testing.T) {
+	v := davAttrHolderNS{Attr: Attr{Name: Name{Space: "DAV:", Local: "lang"}, Value: "en"}, Value: "hi"}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal(%v): %s", v, err)
+	}
+	want := `<prop xmlns="DAV:" xmlns:ns0="DAV:" ns0:lang="en">hi</prop>`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
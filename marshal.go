@@ -96,7 +96,138 @@ type Encoder struct {
 
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{rootNs2Pfx(), printer{Writer: bufio.NewWriter(w)}}
+	enc := &Encoder{context: rootNs2Pfx(), printer: printer{Writer: bufio.NewWriter(w)}}
+	enc.printer.encoder = enc
+	return enc
+}
+
+// Marshaler is the interface implemented by objects that can marshal
+// themselves into valid XML elements.
+//
+// MarshalXML encodes the receiver as zero or more XML elements. It
+// may call e.EncodeElement or e.EncodeToken to produce its output,
+// and is free to use or discard start, the name and attributes that
+// the enclosing structure would otherwise have used for the element.
+type Marshaler interface {
+	MarshalXML(e *Encoder, start StartElement) error
+}
+
+// MarshalerAttr is the interface implemented by objects that can
+// marshal themselves into a single XML attribute.
+//
+// MarshalXMLAttr returns the attribute to emit in place of the one
+// that reflection would otherwise produce for name. Returning a zero
+// Attr (one with an empty Name.Local) causes the attribute to be
+// omitted entirely.
+type MarshalerAttr interface {
+	MarshalXMLAttr(name Name) (Attr, error)
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	marshalerAttrType = reflect.TypeOf((*MarshalerAttr)(nil)).Elem()
+	nameType          = reflect.TypeOf(Name{})
+	namePtrType       = reflect.PtrTo(nameType)
+	attrType          = reflect.TypeOf(Attr{})
+)
+
+// dynamicNameField looks for an exported field of type xml.Name or
+// *xml.Name, other than the XMLName field already handled via
+// typeInfo, whose tag carries the ",name" option. Such a field
+// supplies the element name (and namespace) at marshal time rather
+// than at scan time, which lets callers such as WebDAV property
+// handlers emit element names discovered at runtime. A nil *xml.Name
+// is treated as not supplying a name, same as the field being absent.
+func dynamicNameField(typ reflect.Type, val reflect.Value) (Name, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" || sf.Name == "XMLName" {
+			continue
+		}
+		if sf.Type != nameType && sf.Type != namePtrType {
+			continue
+		}
+		if !hasTagOption(sf.Tag.Get("xml"), "name") {
+			continue
+		}
+		fv := val.Field(i)
+		if sf.Type == namePtrType {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		return fv.Interface().(Name), true
+	}
+	return Name{}, false
+}
+
+// hasTagOption reports whether the comma-separated xml struct tag
+// contains the given option.
+func hasTagOption(tag, option string) bool {
+	for _, opt := range strings.Split(tag, ",")[1:] {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isDynamicNameField reports whether finfo is the struct's ",name"
+// field - the one dynamicNameField already consulted for the
+// element's own name - so marshalStruct doesn't also write it out
+// again as an ordinary child element.
+func isDynamicNameField(typ reflect.Type, finfo *fieldInfo) bool {
+	if finfo.flags&fAttr != 0 {
+		return false
+	}
+	sf := typ.FieldByIndex(finfo.idx)
+	return (sf.Type == nameType || sf.Type == namePtrType) && sf.Name != "XMLName" && hasTagOption(sf.Tag.Get("xml"), "name")
+}
+
+// autoPrefix picks an "nsN" prefix not already in use anywhere in
+// nsctx's ancestor chain, for a namespace that needs declaring but has
+// no static prefix of its own, e.g. one discovered at runtime through
+// a MarshalerAttr or an Attr-typed field.
+func autoPrefix(nsctx *context) string {
+	used := func(pfx string) bool {
+		for c := nsctx; c != nil; c = c.parent {
+			for _, v := range c.pfxmap {
+				if v == pfx {
+					return true
+				}
+			}
+			for _, v := range c.attrPfxmap {
+				if v == pfx {
+					return true
+				}
+			}
+			if c.parent == c {
+				break
+			}
+		}
+		return false
+	}
+	for n := 0; ; n++ {
+		pfx := "ns" + strconv.Itoa(n)
+		if !used(pfx) {
+			return pfx
+		}
+	}
+}
+
+// marshalerAttrValue returns fv, or its address, as a MarshalerAttr if
+// either implements the interface.
+func marshalerAttrValue(fv reflect.Value) (MarshalerAttr, bool) {
+	if fv.CanInterface() && fv.Type().Implements(marshalerAttrType) {
+		return fv.Interface().(MarshalerAttr), true
+	}
+	if fv.CanAddr() {
+		if pv := fv.Addr(); pv.CanInterface() && pv.Type().Implements(marshalerAttrType) {
+			return pv.Interface().(MarshalerAttr), true
+		}
+	}
+	return nil, false
 }
 
 // Indent sets the encoder to generate XML in which each element
@@ -112,20 +243,80 @@ func (enc *Encoder) Indent(prefix, indent string) {
 // See the documentation for Marshal for details about the conversion
 // of Go values to XML.
 func (enc *Encoder) Encode(v interface{}) error {
-	err := enc.marshalValue(reflect.ValueOf(v), nil, enc.context.child())
+	err := enc.marshalValue(reflect.ValueOf(v), nil, enc.childContext())
 	if err != nil {
 		return err
 	}
 	return enc.Flush()
 }
 
+// EncodeElement writes the XML encoding of v to the stream, using
+// start.Name as the name of the outermost element; start.Attr is not
+// written. It is
+// meant to be called from a Marshaler's MarshalXML method, in place
+// of a plain Encode call, to preserve the name the enclosing
+// structure chose for the element.
+func (enc *Encoder) EncodeElement(v interface{}, start StartElement) error {
+	finfo := &fieldInfo{name: start.Name.Local, xmlns: start.Name.Space}
+	if err := enc.marshalValue(reflect.ValueOf(v), finfo, enc.childContext()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// marshalInterface invokes a Marshaler, handing it this Encoder so it
+// can write its own tokens and sub-elements. start is built using the
+// same name precedence marshalValue itself falls back to: the field
+// tag, if there is one, else the type name - so a Marshaler trusting
+// the passed-in start still gets a sensible name at the top level,
+// where there's no enclosing field to name it.
+func (p *printer) marshalInterface(val Marshaler, finfo *fieldInfo, typ reflect.Type) error {
+	start := StartElement{}
+	if finfo != nil {
+		start.Name.Local = finfo.name
+		start.Name.Space = finfo.xmlns
+	} else {
+		start.Name.Local = typ.Name()
+	}
+	return val.MarshalXML(p.encoder, start)
+}
+
 type printer struct {
 	*bufio.Writer
-	indent     string
-	prefix     string
-	depth      int
-	indentedIn bool
-	putNewline bool
+	indent      string
+	prefix      string
+	depth       int
+	indentedIn  bool
+	putNewline  bool
+	encoder     *Encoder
+	tags        []elemFrame
+	canon       CanonMode
+	minimalText bool
+}
+
+// escapeText writes s as XML character data, using the canonical
+// escaping rules if the encoder is in a canonicalization mode, or the
+// minimal escaping rules if EscapeText(true) was called, and falling
+// back to the default Escape otherwise.
+func (p *printer) escapeText(s []byte) {
+	switch {
+	case p.canon != CanonNone:
+		canonEscapeText(p, s)
+	case p.minimalText:
+		minimalEscapeText(p, s)
+	default:
+		Escape(p, s)
+	}
+}
+
+// escapeAttr writes s as an XML attribute value, using the canonical
+// escaping rules if the encoder is in a canonicalization mode.
+func (p *printer) escapeAttr(s []byte) {
+	if p.canon != CanonNone {
+		canonEscapeAttr(p, s)
+		return
+	}
+	Escape(p, s)
 }
 
 // marshalValue writes one or more XML elements representing val.
@@ -164,6 +355,16 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, nsctx *conte
 		nsctx.xmlns = finfo.xmlns
 	}
 
+	// Check for marshaler.
+	if val.CanInterface() && typ.Implements(marshalerType) {
+		return p.marshalInterface(val.Interface().(Marshaler), finfo, typ)
+	}
+	if val.CanAddr() {
+		if pv := val.Addr(); pv.CanInterface() && pv.Type().Implements(marshalerType) {
+			return p.marshalInterface(pv.Interface().(Marshaler), finfo, typ)
+		}
+	}
+
 	tinfo, err := getTypeInfo(typ, nsctx.xmlns)
 	if err != nil {
 		return err
@@ -171,13 +372,11 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, nsctx *conte
 
 	// Precedence for the XML element name is:
 	// 1. XMLName field in underlying struct;
-	// 2. field name/tag in the struct field; and
-	// 3. type name
+	// 2. any other ",name"-tagged xml.Name field in the struct;
+	// 3. field name/tag in the struct field; and
+	// 4. type name
 	var pfx, name string
 	if tinfo.xmlname != nil {
-		// BUG(cjyar): It's not possible to specify a
-		// prefix-to-namespace mapping at runtime, via the
-		// xml.Name structure.
 		xmlname := tinfo.xmlname
 		if xmlname.name != "" {
 			nsctx.xmlns, name = xmlname.xmlns, xmlname.name
@@ -185,6 +384,11 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, nsctx *conte
 			nsctx.xmlns, name = v.Space, v.Local
 		}
 	}
+	if name == "" && kind == reflect.Struct {
+		if v, ok := dynamicNameField(typ, val); ok {
+			nsctx.xmlns, name = v.Space, v.Local
+		}
+	}
 	if name == "" && finfo != nil {
 		pfx, name = finfo.prefix, finfo.name
 	}
@@ -222,51 +426,117 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, nsctx *conte
 		}
 		p.WriteString(`="`)
 		// TODO: EscapeString, to avoid the allocation.
-		Escape(p, []byte(nsctx.xmlns))
+		p.escapeAttr([]byte(nsctx.xmlns))
 		p.WriteByte('"')
 	}
 
-	// Scan attributes for new namespaces before outputting the attributes.
-	for _, attrInfo := range tinfo.fields {
+	// Resolve attribute values up front, giving MarshalerAttr a chance
+	// to override or omit each one, so both passes below agree.
+	attrOverride := make(map[int]Attr)
+	attrOmit := make(map[int]bool)
+	for i := range tinfo.fields {
+		attrInfo := &tinfo.fields[i]
 		if attrInfo.flags&fAttr == 0 {
 			continue
 		}
 		fv := attrInfo.value(val)
 		if attrInfo.flags&fOmitEmpty != 0 && isEmptyValue(fv) {
+			attrOmit[i] = true
+			continue
+		}
+		if ma, ok := marshalerAttrValue(fv); ok {
+			a, err := ma.MarshalXMLAttr(Name{Space: attrInfo.xmlns, Local: attrInfo.name})
+			if err != nil {
+				return err
+			}
+			if a.Name.Local == "" {
+				attrOmit[i] = true
+				continue
+			}
+			attrOverride[i] = a
+		} else if fv.Type() == attrType {
+			// An Attr-typed field is emitted as-is, with its
+			// own runtime Space/Local, e.g. for WebDAV property
+			// names discovered from the request.
+			a := fv.Interface().(Attr)
+			if a.Name.Local == "" {
+				attrOmit[i] = true
+				continue
+			}
+			attrOverride[i] = a
+		}
+	}
+
+	// Attributes are written in field order, except in a
+	// canonicalization mode, where they must be sorted by namespace
+	// URI and then local name.
+	var attrOrder []int
+	for i := range tinfo.fields {
+		attrInfo := &tinfo.fields[i]
+		if attrInfo.flags&fAttr == 0 || attrOmit[i] {
 			continue
 		}
-		_, attrIsMapped := nsctx.Get(attrInfo.xmlns)
-		if attrInfo.xmlns != "" && !attrIsMapped {
-			if attrInfo.prefix == "" {
-				return fmt.Errorf("Attribute %s of %s needs a prefix", attrInfo.name, name)
+		attrOrder = append(attrOrder, i)
+	}
+	if p.canon != CanonNone {
+		sortAttrOrder(attrOrder, tinfo, attrOverride)
+	}
+
+	// Scan attributes for new namespaces before outputting the attributes.
+	for _, i := range attrOrder {
+		attrInfo := &tinfo.fields[i]
+		xmlns, prefix := attrInfo.xmlns, attrInfo.prefix
+		if a, ok := attrOverride[i]; ok {
+			xmlns = a.Name.Space
+		}
+		_, attrIsMapped := nsctx.GetAttr(xmlns)
+		if xmlns != "" && !attrIsMapped {
+			// Unlike an element, an attribute can't fall back to an
+			// unprefixed (default) namespace declaration - not even
+			// one the enclosing element already declared for itself
+			// - so a namespace with no prefix usable by an attribute
+			// - e.g. one discovered at runtime through a
+			// MarshalerAttr or an Attr-typed field, or one that only
+			// matches the element's own default namespace - gets one
+			// auto-assigned here instead of silently losing it.
+			if prefix == "" {
+				prefix = autoPrefix(nsctx)
 			}
-			nsctx.pfxmap[attrInfo.xmlns] = attrInfo.prefix
+			nsctx.attrPfxmap[xmlns] = prefix
 			p.WriteString(" xmlns:")
-			p.WriteString(attrInfo.prefix)
+			p.WriteString(prefix)
 			p.WriteString(`="`)
-			Escape(p, []byte(attrInfo.xmlns))
+			p.escapeAttr([]byte(xmlns))
 			p.WriteByte('"')
 		}
 	}
 
 	// Attributes
-	for _, attrInfo := range tinfo.fields {
-		if attrInfo.flags&fAttr == 0 {
+	for _, i := range attrOrder {
+		attrInfo := &tinfo.fields[i]
+		if a, ok := attrOverride[i]; ok {
+			p.WriteByte(' ')
+			prefix, _ := nsctx.GetAttr(a.Name.Space)
+			if prefix != "" {
+				p.WriteString(prefix)
+				p.WriteByte(':')
+			}
+			p.WriteString(a.Name.Local)
+			p.WriteString(`="`)
+			p.escapeAttr([]byte(a.Value))
+			p.WriteByte('"')
 			continue
 		}
 		fv := attrInfo.value(val)
-		if attrInfo.flags&fOmitEmpty != 0 && isEmptyValue(fv) {
-			continue
-		}
 		p.WriteByte(' ')
-		prefix, _ := nsctx.Get(attrInfo.xmlns)
+		prefix, _ := nsctx.GetAttr(attrInfo.xmlns)
 		if prefix != "" {
 			p.WriteString(prefix)
 			p.WriteByte(':')
 		}
 		p.WriteString(attrInfo.name)
 		p.WriteString(`="`)
-		if err := p.marshalSimple(fv.Type(), fv); err != nil {
+		if err := p.marshalSimple(fv.Type(), fv, true); err != nil {
 			return err
 		}
 		p.WriteByte('"')
@@ -276,7 +546,7 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, nsctx *conte
 	if val.Kind() == reflect.Struct {
 		err = p.marshalStruct(tinfo, val, nsctx)
 	} else {
-		err = p.marshalSimple(typ, val)
+		err = p.marshalSimple(typ, val, false)
 	}
 	if err != nil {
 		return err
@@ -300,7 +570,15 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, nsctx *conte
 
 var timeType = reflect.TypeOf(time.Time{})
 
-func (p *printer) marshalSimple(typ reflect.Type, val reflect.Value) error {
+// marshalSimple writes a non-struct value. attr reports whether val is
+// being written as an attribute value rather than character data,
+// which matters when the encoder is canonicalizing output: attribute
+// and text content use different escaping rules.
+func (p *printer) marshalSimple(typ reflect.Type, val reflect.Value, attr bool) error {
+	escape := p.escapeText
+	if attr {
+		escape = p.escapeAttr
+	}
 	// Normally we don't see structs, but this can happen for an attribute.
 	if val.Type() == timeType {
 		p.WriteString(val.Interface().(time.Time).Format(time.RFC3339Nano))
@@ -315,7 +593,7 @@ func (p *printer) marshalSimple(typ reflect.Type, val reflect.Value) error {
 		p.WriteString(strconv.FormatFloat(val.Float(), 'g', -1, val.Type().Bits()))
 	case reflect.String:
 		// TODO: Add EscapeString.
-		Escape(p, []byte(val.String()))
+		escape([]byte(val.String()))
 	case reflect.Bool:
 		p.WriteString(strconv.FormatBool(val.Bool()))
 	case reflect.Array:
@@ -324,10 +602,10 @@ func (p *printer) marshalSimple(typ reflect.Type, val reflect.Value) error {
 		for i := range bytes {
 			bytes[i] = val.Index(i).Interface().(byte)
 		}
-		Escape(p, bytes)
+		escape(bytes)
 	case reflect.Slice:
 		// will be []byte
-		Escape(p, val.Bytes())
+		escape(val.Bytes())
 	default:
 		return &UnsupportedTypeError{typ}
 	}
@@ -347,29 +625,20 @@ func (p *printer) marshalStruct(tinfo *typeInfo, val reflect.Value, nsctx *conte
 		if finfo.flags&(fAttr) != 0 {
 			continue
 		}
+		if isDynamicNameField(val.Type(), finfo) {
+			continue
+		}
 		vf := finfo.value(val)
 		switch finfo.flags & fMode {
 		case fCharData:
-			var scratch [64]byte
-			switch vf.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				Escape(p, strconv.AppendInt(scratch[:0], vf.Int(), 10))
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-				Escape(p, strconv.AppendUint(scratch[:0], vf.Uint(), 10))
-			case reflect.Float32, reflect.Float64:
-				Escape(p, strconv.AppendFloat(scratch[:0], vf.Float(), 'g', -1, vf.Type().Bits()))
-			case reflect.Bool:
-				Escape(p, strconv.AppendBool(scratch[:0], vf.Bool()))
-			case reflect.String:
-				Escape(p, []byte(vf.String()))
-			case reflect.Slice:
-				if elem, ok := vf.Interface().([]byte); ok {
-					Escape(p, elem)
-				}
-			case reflect.Struct:
-				if vf.Type() == timeType {
-					Escape(p, []byte(vf.Interface().(time.Time).Format(time.RFC3339Nano)))
-				}
+			data := charDataBytes(vf)
+			if data == nil {
+				continue
+			}
+			if p.canon == CanonNone && isCDataField(val.Type(), finfo) {
+				writeCDATA(p, data)
+			} else {
+				p.escapeText(data)
 			}
 			continue
 
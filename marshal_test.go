@@ -0,0 +1,91 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type upperElt struct {
+	Val string
+}
+
+func (u upperElt) MarshalXML(e *Encoder, start StartElement) error {
+	start.Name.Local = "upper"
+	return e.EncodeElement(strings.ToUpper(u.Val), start)
+}
+
+type prefixedAttr struct {
+	Val string
+}
+
+func (p prefixedAttr) MarshalXMLAttr(name Name) (Attr, error) {
+	if p.Val == "" {
+		return Attr{}, nil
+	}
+	return Attr{Name: name, Value: "pfx:" + p.Val}, nil
+}
+
+type marshalerHolder struct {
+	XMLName Name         `xml:"holder"`
+	Tag     prefixedAttr `xml:"tag,attr"`
+	Elt     upperElt     `xml:"elt"`
+}
+
+func TestMarshalInterface(t *testing.T) {
+	h := marshalerHolder{Tag: prefixedAttr{Val: "a"}, Elt: upperElt{Val: "hello"}}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(h); err != nil {
+		t.Fatalf("Encode %v: %s", h, err)
+	}
+	want := `<holder tag="pfx:a"><upper>HELLO</upper></holder>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type davLangAttr struct {
+	Val string
+}
+
+func (d davLangAttr) MarshalXMLAttr(name Name) (Attr, error) {
+	return Attr{Name: Name{Space: "DAV:", Local: "lang"}, Value: d.Val}, nil
+}
+
+type davLangHolder struct {
+	XMLName Name        `xml:"DAV: prop"`
+	Tag     davLangAttr `xml:"lang,attr"`
+	Value   string      `xml:",chardata"`
+}
+
+// TestMarshalerAttrOwnNamespace proves a MarshalerAttr that returns a
+// namespace matching the enclosing element's own namespace still gets
+// its own prefix, instead of silently losing the namespace by reusing
+// the element's unprefixed xmlns="DAV:" declaration.
+func TestMarshalerAttrOwnNamespace(t *testing.T) {
+	h := davLangHolder{Tag: davLangAttr{Val: "en"}, Value: "hi"}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(h); err != nil {
+		t.Fatalf("Encode %v: %s", h, err)
+	}
+	want := `<prop xmlns="DAV:" xmlns:ns0="DAV:" ns0:lang="en">hi</prop>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMarshalAttrOmit(t *testing.T) {
+	h := marshalerHolder{Elt: upperElt{Val: "hi"}}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(h); err != nil {
+		t.Fatalf("Encode %v: %s", h, err)
+	}
+	want := `<holder><upper>HI</upper></holder>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
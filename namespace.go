@@ -25,7 +25,15 @@ type context struct {
 	// namespace. Use this object to add new mappings, and the Get
 	// method to read the current mapping.
 	pfxmap map[string]string
-	parent *context
+	// attrPfxmap holds namespace -> prefix mappings that exist only
+	// to give attributes a usable prefix. Unlike an element, an
+	// attribute can't fall back to an unprefixed (default) xmlns
+	// declaration, so when a namespace is only available in pfxmap
+	// mapped to "", it gets its own entry here instead of
+	// overwriting the element's default-namespace mapping. Use
+	// GetAttr to read it.
+	attrPfxmap map[string]string
+	parent     *context
 }
 
 // Get reads the mapping for this element, including the mappings for
@@ -43,9 +51,30 @@ func (n *context) Get(k string) (string, bool) {
 	return "", false
 }
 
+// GetAttr reads the prefix usable for an attribute in namespace k,
+// including the mappings for all parent elements. Unlike Get, a
+// namespace mapped to the empty (default) prefix doesn't count -
+// an attribute can't use it - so GetAttr keeps looking, including in
+// attrPfxmap, for a namespace declared specifically for attribute use.
+func (n *context) GetAttr(k string) (string, bool) {
+	for c := n; c != nil; c = c.parent {
+		if v, ok := c.pfxmap[k]; ok && v != "" {
+			return v, true
+		}
+		if v, ok := c.attrPfxmap[k]; ok {
+			return v, true
+		}
+		if c.parent == c {
+			break
+		}
+	}
+	return "", false
+}
+
 func (n *context) child() *context {
 	child := &context{}
 	child.pfxmap = make(map[string]string)
+	child.attrPfxmap = make(map[string]string)
 	child.parent = n
 	return child
 }
@@ -53,6 +82,7 @@ func (n *context) child() *context {
 func rootNs2Pfx() *context {
 	n := &context{}
 	n.pfxmap = make(map[string]string)
+	n.attrPfxmap = make(map[string]string)
 	n.parent = n
 	n.pfxmap[XML_NS] = "xml"
 	n.pfxmap[XMLNS_NS] = "xmlns"
@@ -0,0 +1,174 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"fmt"
+	"sort"
+)
+
+// elemFrame records one open element written via EncodeToken, so a
+// matching EndElement can be validated and so nested elements inherit
+// the right namespace-to-prefix mapping.
+type elemFrame struct {
+	name Name
+	ctx  *context
+}
+
+// EncodeToken writes the given XML token to the stream. A Marshaler
+// can use it, together with EncodeElement and Flush, to interleave
+// hand-written tokens with the output of reflection-driven encoding.
+//
+// As a rule, the tokens it accepts are StartElement, EndElement,
+// CharData, Comment, ProcInst and Directive; passing any other type
+// is an error. EncodeToken keeps an internal stack of open elements;
+// an EndElement that does not match the innermost open StartElement
+// is rejected, and Close reports any elements left unclosed; Flush
+// does not check for them.
+func (enc *Encoder) EncodeToken(t Token) error {
+	p := &enc.printer
+	switch t := t.(type) {
+	case StartElement:
+		nsctx := enc.childContext()
+		nsctx.xmlns = t.Name.Space
+		mapPfx, isMapped := nsctx.Get(t.Name.Space)
+
+		p.writeIndent(1)
+		p.WriteByte('<')
+		writeQName(p, mapPfx, t.Name.Local)
+		if t.Name.Space != "" && !isMapped {
+			nsctx.pfxmap[t.Name.Space] = mapPfx
+			p.WriteString(" xmlns")
+			if mapPfx != "" {
+				p.WriteByte(':')
+				p.WriteString(mapPfx)
+			}
+			p.WriteString(`="`)
+			p.escapeAttr([]byte(t.Name.Space))
+			p.WriteByte('"')
+		}
+		// Scan attributes for namespaces not yet declared by an
+		// ancestor element, same as marshalValue does for struct-tag
+		// attributes, auto-assigning a prefix when the caller didn't
+		// supply one of its own. Use GetAttr/attrPfxmap rather than
+		// Get/pfxmap: an attribute can't fall back to a default
+		// (unprefixed) xmlns declaration, even one the enclosing
+		// element just declared for itself, so a namespace mapped
+		// only to "" still needs its own prefix here.
+		attrs := t.Attr
+		if p.canon != CanonNone {
+			// Same order sortAttrOrder gives struct-tag attributes.
+			attrs = append([]Attr(nil), t.Attr...)
+			sort.SliceStable(attrs, func(i, j int) bool {
+				if attrs[i].Name.Space != attrs[j].Name.Space {
+					return attrs[i].Name.Space < attrs[j].Name.Space
+				}
+				return attrs[i].Name.Local < attrs[j].Name.Local
+			})
+		}
+		for _, attr := range attrs {
+			xmlns := attr.Name.Space
+			if xmlns == "" {
+				continue
+			}
+			if _, isMapped := nsctx.GetAttr(xmlns); isMapped {
+				continue
+			}
+			prefix := autoPrefix(nsctx)
+			nsctx.attrPfxmap[xmlns] = prefix
+			p.WriteString(" xmlns:")
+			p.WriteString(prefix)
+			p.WriteString(`="`)
+			p.escapeAttr([]byte(xmlns))
+			p.WriteByte('"')
+		}
+		for _, attr := range attrs {
+			p.WriteByte(' ')
+			mapPfx, _ := nsctx.GetAttr(attr.Name.Space)
+			writeQName(p, mapPfx, attr.Name.Local)
+			p.WriteString(`="`)
+			p.escapeAttr([]byte(attr.Value))
+			p.WriteByte('"')
+		}
+		p.WriteByte('>')
+		p.tags = append(p.tags, elemFrame{name: t.Name, ctx: nsctx})
+
+	case EndElement:
+		if len(p.tags) == 0 {
+			return fmt.Errorf("xml: end element %s without matching start element", t.Name.Local)
+		}
+		top := p.tags[len(p.tags)-1]
+		if top.name != t.Name {
+			return fmt.Errorf("xml: end element %s does not match start element %s", t.Name.Local, top.name.Local)
+		}
+		p.tags = p.tags[:len(p.tags)-1]
+		mapPfx, _ := top.ctx.Get(t.Name.Space)
+		p.writeIndent(-1)
+		p.WriteString("</")
+		writeQName(p, mapPfx, t.Name.Local)
+		p.WriteByte('>')
+
+	case CharData:
+		p.escapeText(t)
+	case Comment:
+		p.writeIndent(0)
+		p.WriteString("<!--")
+		p.Write(t)
+		p.WriteString("-->")
+	case ProcInst:
+		p.writeIndent(0)
+		p.WriteString("<?")
+		p.WriteString(t.Target)
+		if len(t.Inst) > 0 {
+			p.WriteByte(' ')
+			p.Write(t.Inst)
+		}
+		p.WriteString("?>")
+	case Directive:
+		p.writeIndent(0)
+		p.WriteString("<!")
+		p.Write(t)
+		p.WriteByte('>')
+	default:
+		return fmt.Errorf("xml: EncodeToken of invalid token type")
+	}
+	return p.cachedWriteError()
+}
+
+// childContext returns a fresh namespace context for a new outermost
+// element, chained from the innermost element currently open via
+// EncodeToken, if any. Encode and EncodeElement use this too, so a
+// value written through either of them while nested inside an
+// EncodeToken-opened element sees the same namespace mappings
+// EncodeToken itself would, instead of starting back over at the
+// document root.
+func (enc *Encoder) childContext() *context {
+	nsctx := enc.context.child()
+	if tags := enc.printer.tags; len(tags) > 0 {
+		nsctx.parent = tags[len(tags)-1].ctx
+	}
+	return nsctx
+}
+
+// writeQName writes a (possibly prefixed) element or attribute name.
+func writeQName(p *printer, prefix, local string) {
+	if prefix != "" {
+		p.WriteString(prefix)
+		p.WriteByte(':')
+	}
+	p.WriteString(local)
+}
+
+// Close reports an error if any element opened via EncodeToken was
+// never matched with an EndElement; it does not write one itself.
+// Call it after the last EncodeToken/EncodeElement to catch a
+// forgotten EndElement instead of silently emitting unbalanced XML.
+// With no unclosed elements, it's equivalent to Flush.
+func (enc *Encoder) Close() error {
+	if len(enc.printer.tags) > 0 {
+		return fmt.Errorf("xml: unclosed element <%s>", enc.printer.tags[len(enc.printer.tags)-1].name.Local)
+	}
+	return enc.Flush()
+}
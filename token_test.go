@@ -0,0 +1,166 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestEncodeTokenInheritedPrefix proves that an attribute on a nested
+// element, in a namespace the encoder already maps (here the
+// predeclared xml namespace), gets that prefix without a new xmlns
+// declaration.
+func TestEncodeTokenInheritedPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken <a>: %s", err)
+	}
+	if err := enc.EncodeToken(StartElement{
+		Name: Name{Local: "b"},
+		Attr: []Attr{{Name: Name{Space: XML_NS, Local: "lang"}, Value: "en"}},
+	}); err != nil {
+		t.Fatalf("EncodeToken <b>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Local: "b"}}); err != nil {
+		t.Fatalf("EncodeToken </b>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken </a>: %s", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	want := `<a><b xml:lang="en"></b></a>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestEncodeTokenAttrUndeclaredNamespace proves an attribute whose
+// namespace no ancestor element has declared gets its own
+// auto-prefixed xmlns declaration, instead of silently losing its
+// namespace.
+func TestEncodeTokenAttrUndeclaredNamespace(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{
+		Name: Name{Local: "a"},
+		Attr: []Attr{{Name: Name{Space: "custom-ns", Local: "foo"}, Value: "bar"}},
+	}); err != nil {
+		t.Fatalf("EncodeToken <a>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken </a>: %s", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	want := `<a xmlns:ns0="custom-ns" ns0:foo="bar"></a>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestEncodeTokenAttrOwnNamespace proves an attribute whose namespace
+// matches the enclosing element's own (just-declared) default
+// namespace still gets its own prefix, instead of silently losing the
+// namespace by reusing the element's unprefixed xmlns="DAV:"
+// declaration.
+func TestEncodeTokenAttrOwnNamespace(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{
+		Name: Name{Space: "DAV:", Local: "prop"},
+		Attr: []Attr{{Name: Name{Space: "DAV:", Local: "lang"}, Value: "en"}},
+	}); err != nil {
+		t.Fatalf("EncodeToken <prop>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Space: "DAV:", Local: "prop"}}); err != nil {
+		t.Fatalf("EncodeToken </prop>: %s", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	want := `<prop xmlns="DAV:" xmlns:ns0="DAV:" ns0:lang="en"></prop>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestEncodeElementInheritsTokenNamespace proves a value written via
+// EncodeElement while nested inside an element opened with
+// EncodeToken sees that element's namespace mapping, instead of
+// redeclaring the same namespace redundantly.
+func TestEncodeElementInheritsTokenNamespace(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{Name: Name{Space: "ns1", Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken <a>: %s", err)
+	}
+	if err := enc.EncodeElement("x", StartElement{Name: Name{Space: "ns1", Local: "b"}}); err != nil {
+		t.Fatalf("EncodeElement <b>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Space: "ns1", Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken </a>: %s", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	want := `<a xmlns="ns1"><b>x</b></a>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestCloseUnclosedElement proves Close reports an error when an
+// element opened via EncodeToken was never matched with an
+// EndElement.
+func TestCloseUnclosedElement(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken <a>: %s", err)
+	}
+	if err := enc.Close(); err == nil {
+		t.Errorf("Close: got nil error, want an unclosed-element error")
+	}
+}
+
+// TestCloseNoUnclosedElements proves Close behaves like Flush when
+// every element opened via EncodeToken was matched with an
+// EndElement.
+func TestCloseNoUnclosedElements(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken <a>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken </a>: %s", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	want := `<a></a>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestEncodeTokenMismatchedEnd proves EncodeToken rejects an
+// EndElement that doesn't match the innermost open StartElement,
+// rather than silently closing the wrong tag.
+func TestEncodeTokenMismatchedEnd(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeToken(StartElement{Name: Name{Local: "a"}}); err != nil {
+		t.Fatalf("EncodeToken <a>: %s", err)
+	}
+	if err := enc.EncodeToken(EndElement{Name: Name{Local: "b"}}); err == nil {
+		t.Errorf("EncodeToken </b>: got nil error, want a mismatch error")
+	}
+}
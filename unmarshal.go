@@ -0,0 +1,359 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Unmarshaler is the interface implemented by objects that can
+// unmarshal an XML element description of themselves.
+//
+// UnmarshalXML decodes a single XML element beginning with the given
+// start element. If it returns an error, the outer call to Unmarshal
+// stops and returns that error. UnmarshalXML must consume exactly one
+// XML element; it may do so by calling d.DecodeElement on an object
+// of its own type, or by calling d.Token repeatedly to consume the
+// element's tokens directly (e.g. to dispatch on the name or
+// namespace of a nested element before decoding it).
+type Unmarshaler interface {
+	UnmarshalXML(d *Decoder, start StartElement) error
+}
+
+// UnmarshalerAttr is the interface implemented by objects that can
+// unmarshal an XML attribute description of themselves.
+//
+// UnmarshalXMLAttr decodes a single XML attribute. If it returns an
+// error, the outer call to Unmarshal stops and returns that error.
+type UnmarshalerAttr interface {
+	UnmarshalXMLAttr(attr Attr) error
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	unmarshalerAttrType = reflect.TypeOf((*UnmarshalerAttr)(nil)).Elem()
+)
+
+// Decode reads the next XML element from the input stream and stores
+// it in the value pointed to by v, the decode-side counterpart of
+// Encode.
+func (d *Decoder) Decode(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("xml: non-pointer passed to Decode")
+	}
+	return d.unmarshal(val.Elem(), nil)
+}
+
+// DecodeElement works like Decode except that it takes a pointer to
+// the start XML element to decode into v. It is useful when a Token
+// loop has already read the opening StartElement (for example, to
+// dispatch on its name or namespace) and now wants to decode the rest
+// of the element using the usual reflection-based rules. If start is
+// nil, DecodeElement reads the next element from the stream as the
+// start element, like Decode does.
+func (d *Decoder) DecodeElement(v interface{}, start *StartElement) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("xml: non-pointer passed to DecodeElement")
+	}
+	return d.unmarshal(val.Elem(), start)
+}
+
+// unmarshal is the decoder's reflection loop, the counterpart on the
+// decode side of printer.marshalValue. It consumes exactly one XML
+// element from d - start, if given, or else the next StartElement on
+// the stream - and stores it into val, consulting Unmarshaler and
+// UnmarshalerAttr before falling back to matching struct fields by
+// name the way marshalStruct writes them.
+func (d *Decoder) unmarshal(val reflect.Value, start *StartElement) error {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return d.unmarshal(val.Elem(), start)
+	}
+
+	if val.CanAddr() {
+		if pv := val.Addr(); pv.CanInterface() && pv.Type().Implements(unmarshalerType) {
+			if start == nil {
+				s, err := d.nextStart()
+				if err != nil {
+					return err
+				}
+				start = s
+			}
+			return d.unmarshalInterface(pv.Interface().(Unmarshaler), start)
+		}
+	}
+
+	if start == nil {
+		s, err := d.nextStart()
+		if err != nil {
+			return err
+		}
+		start = s
+	}
+
+	if val.Kind() != reflect.Struct || val.Type() == timeType {
+		// A non-struct value takes the element's character data;
+		// nested elements are skipped.
+		var text []byte
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return err
+			}
+			switch t := tok.(type) {
+			case CharData:
+				text = append(text, t...)
+			case StartElement:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			case EndElement:
+				return setSimpleValue(val, string(text))
+			}
+		}
+	}
+
+	tinfo, err := getTypeInfo(val.Type(), start.Name.Space)
+	if err != nil {
+		return err
+	}
+
+	if tinfo.xmlname != nil {
+		tinfo.xmlname.value(val).Set(reflect.ValueOf(start.Name))
+	}
+
+	for _, a := range start.Attr {
+		finfo := findAttrField(tinfo, a.Name)
+		if finfo == nil {
+			continue
+		}
+		fv := finfo.value(val)
+		if ua, ok := unmarshalerAttrValue(fv); ok {
+			if err := d.unmarshalAttr(ua, a); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setSimpleValue(fv, a.Value); err != nil {
+			return err
+		}
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case StartElement:
+			finfo := findElementField(tinfo, t.Name)
+			if finfo == nil {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			fv := finfo.value(val)
+			if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+				elem := reflect.New(fv.Type().Elem()).Elem()
+				if err := d.unmarshal(elem, &t); err != nil {
+					return err
+				}
+				fv.Set(reflect.Append(fv, elem))
+			} else if err := d.unmarshal(fv, &t); err != nil {
+				return err
+			}
+
+		case CharData:
+			if finfo := findCharDataField(tinfo); finfo != nil {
+				if err := setSimpleValue(finfo.value(val), string(t)); err != nil {
+					return err
+				}
+			}
+
+		case EndElement:
+			return nil
+		}
+	}
+}
+
+// nextStart reads tokens from d until it finds a StartElement.
+func (d *Decoder) nextStart() (*StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := tok.(StartElement); ok {
+			return &s, nil
+		}
+	}
+}
+
+// findAttrField finds the attribute field in tinfo matching n.
+func findAttrField(tinfo *typeInfo, n Name) *fieldInfo {
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr == 0 || finfo.name != n.Local {
+			continue
+		}
+		if finfo.xmlns != "" && finfo.xmlns != n.Space {
+			continue
+		}
+		return finfo
+	}
+	return nil
+}
+
+// findElementField finds the child-element field in tinfo matching n:
+// a field tagged with n's exact local name, if there is one, else a
+// ",any" field willing to take whatever element doesn't otherwise
+// match - the same two cases marshalStruct groups together when
+// writing (fElement, fElement|fAny). A field with no static xmlns
+// matches any namespace, the leniency Decode needs for documents in
+// the struct's own default namespace.
+func findElementField(tinfo *typeInfo, n Name) *fieldInfo {
+	var any *fieldInfo
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr != 0 || finfo.flags&fMode&^fAny != fElement {
+			continue
+		}
+		if finfo.xmlns != "" && finfo.xmlns != n.Space {
+			continue
+		}
+		if finfo.flags&fAny != 0 {
+			if any == nil {
+				any = finfo
+			}
+			continue
+		}
+		if finfo.name == n.Local {
+			return finfo
+		}
+	}
+	return any
+}
+
+// findCharDataField finds tinfo's chardata field, if it has one.
+func findCharDataField(tinfo *typeInfo) *fieldInfo {
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr == 0 && finfo.flags&fMode == fCharData {
+			return finfo
+		}
+	}
+	return nil
+}
+
+// setSimpleValue assigns s, converted to fv's kind, into fv. It
+// mirrors the scalar kinds charDataBytes already knows how to render
+// the other way, for marshaling.
+func setSimpleValue(fv reflect.Value, s string) error {
+	if fv.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(s))
+		}
+	}
+	return nil
+}
+
+// Skip reads tokens until it has consumed the end element matching
+// the most recently returned start element. If the Decoder is
+// positioned after a StartElement, Skip reads to the matching
+// EndElement, skipping over any nested elements along the way.
+func (d *Decoder) Skip() error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case StartElement:
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case EndElement:
+			return nil
+		}
+	}
+}
+
+// unmarshalInterface delegates decoding of a single element to val,
+// which must implement Unmarshaler. It is called from the decoder's
+// reflection-based unmarshal path before it falls back to matching
+// struct fields by name.
+func (d *Decoder) unmarshalInterface(val Unmarshaler, start *StartElement) error {
+	return val.UnmarshalXML(d, *start)
+}
+
+// unmarshalAttr delegates decoding of a single attribute to val,
+// which must implement UnmarshalerAttr. It is called from the
+// decoder's attribute-matching loop before it falls back to setting
+// the field via reflection.
+func (d *Decoder) unmarshalAttr(val UnmarshalerAttr, attr Attr) error {
+	return val.UnmarshalXMLAttr(attr)
+}
+
+// unmarshalerAttrValue returns fv, or its address, as an
+// UnmarshalerAttr if either implements the interface. It mirrors
+// marshalerAttrValue on the encode side. A nil pointer fv is
+// allocated first.
+func unmarshalerAttrValue(fv reflect.Value) (UnmarshalerAttr, bool) {
+	if fv.Kind() == reflect.Ptr && fv.CanSet() && fv.Type().Implements(unmarshalerAttrType) {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return fv.Interface().(UnmarshalerAttr), true
+	}
+	if fv.CanAddr() {
+		if pv := fv.Addr(); pv.CanInterface() && pv.Type().Implements(unmarshalerAttrType) {
+			return pv.Interface().(UnmarshalerAttr), true
+		}
+	}
+	return nil, false
+}
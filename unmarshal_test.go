@@ -0,0 +1,59 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"strings"
+	"testing"
+)
+
+type customAttr struct {
+	Val string
+}
+
+func (c *customAttr) UnmarshalXMLAttr(attr Attr) error {
+	c.Val = "custom:" + attr.Value
+	return nil
+}
+
+type customElt struct {
+	Val string
+}
+
+func (c *customElt) UnmarshalXML(d *Decoder, start StartElement) error {
+	var aux struct {
+		Val string `xml:",chardata"`
+	}
+	if err := d.DecodeElement(&aux, &start); err != nil {
+		return err
+	}
+	c.Val = "custom:" + aux.Val
+	return nil
+}
+
+type customHolder struct {
+	XMLName Name       `xml:"holder"`
+	Attr    customAttr `xml:"tag,attr"`
+	Elt     customElt  `xml:"elt"`
+}
+
+// TestUnmarshalCustom proves that Unmarshaler and UnmarshalerAttr are
+// actually consulted by the decoder's reflection loop, not merely
+// defined: both Attr and Elt below are set by their own custom
+// methods rather than a plain string copy.
+func TestUnmarshalCustom(t *testing.T) {
+	str := `<holder tag="a"><elt>hello</elt></holder>`
+	dec := NewDecoder(strings.NewReader(str))
+	obj := &customHolder{}
+	if err := dec.Decode(obj); err != nil {
+		t.Fatalf("Decode %s: %s", str, err)
+	}
+	if obj.Attr.Val != "custom:a" {
+		t.Errorf("Attr.Val = %q, want %q", obj.Attr.Val, "custom:a")
+	}
+	if obj.Elt.Val != "custom:hello" {
+		t.Errorf("Elt.Val = %q, want %q", obj.Elt.Val, "custom:hello")
+	}
+}